@@ -0,0 +1,118 @@
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMemoryPQCacheKnowsAndRemember(t *testing.T) {
+	cache := newMemoryPQCache(2)
+
+	if cache.Knows("endpoint", "hash1") {
+		t.Fatal("Knows reported true before Remember was ever called")
+	}
+
+	cache.Remember("endpoint", "hash1")
+	if !cache.Knows("endpoint", "hash1") {
+		t.Fatal("Knows reported false right after Remember")
+	}
+	if cache.Knows("other-endpoint", "hash1") {
+		t.Fatal("Knows is not scoped per endpoint")
+	}
+}
+
+func TestMemoryPQCacheEvictsOldestWhenFull(t *testing.T) {
+	cache := newMemoryPQCache(2)
+
+	cache.Remember("e", "hash1")
+	cache.Remember("e", "hash2")
+	cache.Remember("e", "hash3")
+
+	if cache.Knows("e", "hash1") {
+		t.Fatal("hash1 should have been evicted once the cache filled up")
+	}
+	if !cache.Knows("e", "hash2") || !cache.Knows("e", "hash3") {
+		t.Fatal("the two most recently remembered hashes should still be known")
+	}
+}
+
+// apqServer replies to Automatic Persisted Queries requests: until it
+// has been sent the query text for a hash it returns
+// persistedQueryNotFound, same as a real server asked for a hash it's
+// never been given the query text for. If
+// alwaysMiss is set it keeps returning persistedQueryNotFound even once
+// the client sends the full query, emulating a server that rejects APQ
+// outright.
+func apqServer(t *testing.T, alwaysMiss bool) *httptest.Server {
+	t.Helper()
+	seenQuery := map[string]bool{}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Query      string `json:"query"`
+			Extensions struct {
+				PersistedQuery struct {
+					Sha256Hash string `json:"sha256Hash"`
+				} `json:"persistedQuery"`
+			} `json:"extensions"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Fatalf("decode request body: %v", err)
+		}
+		hash := body.Extensions.PersistedQuery.Sha256Hash
+
+		if body.Query != "" {
+			seenQuery[hash] = true
+		}
+		if !alwaysMiss && seenQuery[hash] {
+			w.Write([]byte(`{"data":{"ok":true}}`))
+			return
+		}
+		w.Write([]byte(`{"errors":[{"message":"PersistedQueryNotFound"}]}`))
+	}))
+}
+
+func TestRunWithJSONAPQRemembersHashOnSuccess(t *testing.T) {
+	srv := apqServer(t, false)
+	defer srv.Close()
+
+	cache := newMemoryPQCache(defaultPQCacheSize)
+	client := NewClient(srv.URL, WithAutomaticPersistedQueries(), WithPersistedQueryCache(cache))
+
+	req := NewRequest("query { ok }")
+	var resp struct {
+		Ok bool `json:"ok"`
+	}
+	if err := client.Run(context.Background(), req, &resp); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	hash := persistedQueryHash(req.q)
+	if !cache.Knows(srv.URL, hash) {
+		t.Fatal("expected the hash to be remembered after a successful request")
+	}
+}
+
+func TestRunWithJSONAPQDoesNotRememberPermanentMiss(t *testing.T) {
+	srv := apqServer(t, true)
+	defer srv.Close()
+
+	cache := newMemoryPQCache(defaultPQCacheSize)
+	client := NewClient(srv.URL, WithAutomaticPersistedQueries(), WithPersistedQueryCache(cache))
+
+	req := NewRequest("query { ok }")
+	var resp struct {
+		Ok bool `json:"ok"`
+	}
+	err := client.Run(context.Background(), req, &resp)
+	if err == nil {
+		t.Fatal("expected an error, the server always rejects this query")
+	}
+
+	hash := persistedQueryHash(req.q)
+	if cache.Knows(srv.URL, hash) {
+		t.Fatal("a hash the server still rejects after the retry must not be remembered")
+	}
+}
@@ -1,48 +1,52 @@
 // Package graphql provides a low level GraphQL client.
 //
-//  // create a client (safe to share across requests)
-//  client := graphql.NewClient("https://machinebox.io/graphql")
+//	// create a client (safe to share across requests)
+//	client := graphql.NewClient("https://machinebox.io/graphql")
 //
-//  // make a request
-//  req := graphql.NewRequest(`
-//      query ($key: String!) {
-//          items (id:$key) {
-//              field1
-//              field2
-//              field3
-//          }
-//      }
-//  `)
+//	// make a request
+//	req := graphql.NewRequest(`
+//	    query ($key: String!) {
+//	        items (id:$key) {
+//	            field1
+//	            field2
+//	            field3
+//	        }
+//	    }
+//	`)
 //
-//  // set any variables
-//  req.Var("key", "value")
+//	// set any variables
+//	req.Var("key", "value")
 //
-//  // run it and capture the response
-//  var respData ResponseStruct
-//  if err := client.Run(ctx, req, &respData); err != nil {
-//      log.Fatal(err)
-//  }
+//	// run it and capture the response
+//	var respData ResponseStruct
+//	if err := client.Run(ctx, req, &respData); err != nil {
+//	    log.Fatal(err)
+//	}
 //
-// Specify client
+// # Specify client
 //
 // To specify your own http.Client, use the WithHTTPClient option:
-//  httpclient := &http.Client{}
-//  client := graphql.NewClient("https://machinebox.io/graphql", graphql.WithHTTPClient(httpclient))
+//
+//	httpclient := &http.Client{}
+//	client := graphql.NewClient("https://machinebox.io/graphql", graphql.WithHTTPClient(httpclient))
 package graphql
 
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
+	"mime"
 	"mime/multipart"
 	"net/http"
 	"net/textproto"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/pkg/errors"
@@ -65,6 +69,9 @@ type Client struct {
 	Log func(s string)
 
 	buildHeaderFunc BuildHeaderFunc
+
+	useAPQ  bool
+	pqCache PQCache
 }
 
 // NewClient makes a new Client capable of making GraphQL requests.
@@ -89,8 +96,8 @@ func (c *Client) logf(format string, args ...interface{}) {
 // Run executes the query and unmarshals the response from the data field
 // into the response object.
 // Pass in a nil response object to skip response parsing.
-// If the request fails or the server returns an error, the first error
-// will be returned.
+// If the server returns one or more errors, they are returned as a
+// GraphQLErrors.
 func (c *Client) Run(ctx context.Context, req *Request, resp interface{}) error {
 	select {
 	case <-ctx.Done():
@@ -107,6 +114,9 @@ func (c *Client) Run(ctx context.Context, req *Request, resp interface{}) error
 }
 
 func (c *Client) runWithJSON(ctx context.Context, req *Request, resp interface{}) error {
+	if c.useAPQ {
+		return c.runWithJSONAPQ(ctx, req, resp)
+	}
 	var requestBody bytes.Buffer
 	requestBodyObj := struct {
 		Query     string                 `json:"query"`
@@ -154,12 +164,180 @@ func (c *Client) runWithJSON(ctx context.Context, req *Request, resp interface{}
 		return errors.Wrap(err, "decoding response")
 	}
 	if len(gr.Errors) > 0 {
-		// return first error
-		return gr.Errors[0]
+		// return all errors
+		return gr.Errors
 	}
 	return nil
 }
 
+// PQCache tracks, per endpoint, which persisted-query hashes the server
+// is already known to have cached, so WithAutomaticPersistedQueries can
+// skip sending the full query text on later requests. Implementations
+// must be safe for concurrent use.
+type PQCache interface {
+	// Knows reports whether the server behind endpoint is known to
+	// already have the query for hash persisted.
+	Knows(endpoint, hash string) bool
+	// Remember records that the server behind endpoint now has the
+	// query for hash persisted.
+	Remember(endpoint, hash string)
+}
+
+const defaultPQCacheSize = 1024
+
+// memoryPQCache is the default PQCache: an in-process map bounded to a
+// fixed number of entries, evicted oldest-first once full.
+type memoryPQCache struct {
+	mu      sync.Mutex
+	known   map[string]struct{}
+	order   []string
+	maxSize int
+}
+
+func newMemoryPQCache(maxSize int) *memoryPQCache {
+	return &memoryPQCache{known: make(map[string]struct{}), maxSize: maxSize}
+}
+
+func (c *memoryPQCache) key(endpoint, hash string) string {
+	return endpoint + "|" + hash
+}
+
+func (c *memoryPQCache) Knows(endpoint, hash string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.known[c.key(endpoint, hash)]
+	return ok
+}
+
+func (c *memoryPQCache) Remember(endpoint, hash string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	k := c.key(endpoint, hash)
+	if _, ok := c.known[k]; ok {
+		return
+	}
+	if len(c.order) >= c.maxSize {
+		oldest := c.order[0]
+		c.order = c.order[1:]
+		delete(c.known, oldest)
+	}
+	c.known[k] = struct{}{}
+	c.order = append(c.order, k)
+}
+
+// persistedQueryNotFound is the error message servers use in the
+// Automatic Persisted Queries protocol to ask the client to retry with
+// the full query text.
+const persistedQueryNotFound = "PersistedQueryNotFound"
+
+func persistedQueryHash(query string) string {
+	sum := sha256.Sum256([]byte(query))
+	return hex.EncodeToString(sum[:])
+}
+
+func persistedQueryExtensions(hash string) map[string]interface{} {
+	return map[string]interface{}{
+		"persistedQuery": map[string]interface{}{
+			"version":    1,
+			"sha256Hash": hash,
+		},
+	}
+}
+
+func isPersistedQueryNotFound(gr *graphResponse) bool {
+	for _, e := range gr.Errors {
+		if e.Message == persistedQueryNotFound {
+			return true
+		}
+	}
+	return false
+}
+
+// apqOperationsJSON builds the request payload for Automatic Persisted
+// Queries: the query text is included only when includeQuery is true,
+// e.g. on the first request for a hash or after a PersistedQueryNotFound
+// response.
+func apqOperationsJSON(req *Request, hash string, includeQuery bool) ([]byte, error) {
+	bodyObj := struct {
+		Query      string                 `json:"query,omitempty"`
+		Variables  map[string]interface{} `json:"variables"`
+		Extensions map[string]interface{} `json:"extensions"`
+	}{
+		Variables:  req.vars,
+		Extensions: persistedQueryExtensions(hash),
+	}
+	if includeQuery {
+		bodyObj.Query = req.q
+	}
+	return json.Marshal(&bodyObj)
+}
+
+func (c *Client) runWithJSONAPQ(ctx context.Context, req *Request, resp interface{}) error {
+	hash := persistedQueryHash(req.q)
+	known := c.pqCache.Knows(c.endpoint, hash)
+
+	gr, err := c.doJSONAPQRequest(ctx, req, resp, hash, !known)
+	if err != nil {
+		return err
+	}
+	if isPersistedQueryNotFound(gr) {
+		gr, err = c.doJSONAPQRequest(ctx, req, resp, hash, true)
+		if err != nil {
+			return err
+		}
+	}
+	if !isPersistedQueryNotFound(gr) {
+		c.pqCache.Remember(c.endpoint, hash)
+	}
+
+	if len(gr.Errors) > 0 {
+		return gr.Errors
+	}
+	return nil
+}
+
+func (c *Client) doJSONAPQRequest(ctx context.Context, req *Request, resp interface{}, hash string, includeQuery bool) (*graphResponse, error) {
+	reqBytes, err := apqOperationsJSON(req, hash, includeQuery)
+	if err != nil {
+		return nil, errors.Wrap(err, "encode body")
+	}
+	c.logf(">> variables: %v", req.vars)
+	c.logf(">> persisted query hash: %s (include query: %v)", hash, includeQuery)
+
+	gr := &graphResponse{Data: resp}
+	r, err := http.NewRequest(http.MethodPost, c.endpoint, bytes.NewReader(reqBytes))
+	if err != nil {
+		return nil, err
+	}
+	r.Close = c.closeReq
+	r.Header.Set("Content-Type", "application/json; charset=utf-8")
+	r.Header.Set("Accept", "application/json; charset=utf-8")
+	for key, values := range req.Header {
+		for _, value := range values {
+			r.Header.Add(key, value)
+		}
+	}
+	c.logf(">> headers: %v", r.Header)
+	r = r.WithContext(ctx)
+	res, err := c.httpClient.Do(r)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, res.Body); err != nil {
+		return nil, errors.Wrap(err, "reading body")
+	}
+	c.logf("<< %s", buf.String())
+	if err := json.NewDecoder(&buf).Decode(&gr); err != nil {
+		if res.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("graphql: server returned a non-200 status code: %v", res.StatusCode)
+		}
+		return nil, errors.Wrap(err, "decoding response")
+	}
+	return gr, nil
+}
+
 var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
 
 func escapeQuotes(s string) string {
@@ -180,6 +358,11 @@ func createFormFile(w *multipart.Writer, fieldname, filename string, contentType
 }
 
 func (c *Client) runWithPostFields(ctx context.Context, req *Request, resp interface{}) error {
+	if c.useAPQ && len(req.files) == 0 {
+		// Files can't be replayed if a PersistedQueryNotFound response
+		// forces a retry, so APQ is only attempted for file-less requests.
+		return c.runWithPostFieldsAPQ(ctx, req, resp)
+	}
 	var requestBody bytes.Buffer
 	requestBodyObj := struct {
 		Query     string                 `json:"query"`
@@ -265,15 +448,333 @@ func (c *Client) runWithPostFields(ctx context.Context, req *Request, resp inter
 		return errors.Wrap(err, "decoding response")
 	}
 	if len(gr.Errors) > 0 {
-		// return first error
-		return gr.Errors[0]
+		// return all errors
+		return gr.Errors
 	}
 	return nil
 }
 
+// Patch is one part of a multipart/mixed incremental delivery response,
+// as produced by a query using @defer/@stream.
+type Patch struct {
+	Path    []interface{}
+	Data    json.RawMessage
+	Errors  GraphQLErrors
+	HasNext bool
+}
+
+// RunStream executes req expecting an incremental delivery response
+// (multipart/mixed, per the @defer/@stream spec) and returns a channel
+// of Patches. The channel is closed once the final patch (HasNext ==
+// false) has been delivered, or once the stream ends early because of
+// an error.
+func (c *Client) RunStream(ctx context.Context, req *Request) (<-chan Patch, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	var requestBody bytes.Buffer
+	requestBodyObj := struct {
+		Query     string                 `json:"query"`
+		Variables map[string]interface{} `json:"variables"`
+	}{
+		Query:     req.q,
+		Variables: req.vars,
+	}
+	if err := json.NewEncoder(&requestBody).Encode(requestBodyObj); err != nil {
+		return nil, errors.Wrap(err, "encode body")
+	}
+	c.logf(">> variables: %v", req.vars)
+	c.logf(">> query: %s", req.q)
+
+	r, err := http.NewRequest(http.MethodPost, c.endpoint, &requestBody)
+	if err != nil {
+		return nil, err
+	}
+	r.Close = c.closeReq
+	r.Header.Set("Content-Type", "application/json; charset=utf-8")
+	r.Header.Set("Accept", "multipart/mixed; deferSpec=20220824")
+	for key, values := range req.Header {
+		for _, value := range values {
+			r.Header.Add(key, value)
+		}
+	}
+	c.logf(">> headers: %v", r.Header)
+	r = r.WithContext(ctx)
+
+	res, err := c.httpClient.Do(r)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		return nil, fmt.Errorf("graphql: server returned a non-200 status code: %v", res.StatusCode)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	if err != nil {
+		res.Body.Close()
+		return nil, errors.Wrap(err, "parsing content type")
+	}
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		res.Body.Close()
+		return nil, fmt.Errorf("graphql: server did not respond with multipart/mixed, got %q", mediaType)
+	}
+	boundary := params["boundary"]
+	if boundary == "" {
+		res.Body.Close()
+		return nil, errors.New("graphql: multipart response missing boundary")
+	}
+
+	patches := make(chan Patch)
+	go c.streamPatches(ctx, res.Body, boundary, patches)
+	return patches, nil
+}
+
+// incrementalEntry is one entry of a part's "incremental" array, each
+// describing a single deferred/streamed result.
+type incrementalEntry struct {
+	Path   []interface{}   `json:"path"`
+	Data   json.RawMessage `json:"data"`
+	Errors GraphQLErrors   `json:"errors"`
+}
+
+// streamPatches reads one multipart part at a time off body and sends
+// one Patch per incremental result on patches, until the final patch,
+// an error, or ctx cancellation ends the stream.
+//
+// The first part is the initial response: "data"/"errors" sit at the
+// part's top level. Every subsequent part wraps one or more
+// deferred/streamed results in an "incremental" array, each with its
+// own "path"/"data"/"errors" (deferSpec=20220824 incremental delivery
+// format); a single part can carry more than one incremental entry.
+func (c *Client) streamPatches(ctx context.Context, body io.ReadCloser, boundary string, patches chan<- Patch) {
+	defer body.Close()
+	defer close(patches)
+
+	mr := multipart.NewReader(body, boundary)
+	for {
+		part, err := mr.NextPart()
+		if err != nil {
+			return
+		}
+
+		var envelope struct {
+			Path        []interface{}      `json:"path"`
+			Data        json.RawMessage    `json:"data"`
+			Errors      GraphQLErrors      `json:"errors"`
+			HasNext     *bool              `json:"hasNext"`
+			Incremental []incrementalEntry `json:"incremental"`
+		}
+		err = json.NewDecoder(part).Decode(&envelope)
+		part.Close()
+		if err != nil {
+			return
+		}
+
+		hasNext := envelope.HasNext == nil || *envelope.HasNext
+		c.logf("<< patch: hasNext=%v incremental=%d", hasNext, len(envelope.Incremental))
+
+		entries := envelope.Incremental
+		if entries == nil {
+			// Initial response: not wrapped in "incremental".
+			entries = []incrementalEntry{{Path: envelope.Path, Data: envelope.Data, Errors: envelope.Errors}}
+		}
+
+		for i, entry := range entries {
+			// Only the last entry derived from this part carries the
+			// part's hasNext; earlier entries in the same part are
+			// necessarily followed by more data.
+			entryHasNext := hasNext || i < len(entries)-1
+			select {
+			case patches <- Patch{Path: entry.Path, Data: entry.Data, Errors: entry.Errors, HasNext: entryHasNext}:
+			case <-ctx.Done():
+				return
+			}
+		}
+		if !hasNext {
+			return
+		}
+	}
+}
+
+// ApplyPatches applies an incremental delivery stream to initial,
+// splicing each patch's data into the response at the location named by
+// its Path. initial must be a pointer to the struct originally decoded
+// from the request's non-deferred data.
+func ApplyPatches(initial interface{}, patches <-chan Patch) error {
+	raw, err := json.Marshal(initial)
+	if err != nil {
+		return errors.Wrap(err, "marshal initial data")
+	}
+	var tree interface{}
+	if err := json.Unmarshal(raw, &tree); err != nil {
+		return errors.Wrap(err, "decode initial data")
+	}
+
+	// Keep ranging over patches until streamPatches closes it even once
+	// firstErr is set: patches is unbuffered, and streamPatches blocks
+	// sending the next part until something receives, so returning early
+	// here would leave its goroutine (and the open response body) stuck
+	// forever on a remaining part.
+	var firstErr error
+	for patch := range patches {
+		if firstErr != nil {
+			continue
+		}
+		if len(patch.Errors) > 0 {
+			firstErr = patch.Errors[0]
+			continue
+		}
+		if len(patch.Data) == 0 {
+			continue
+		}
+		var value interface{}
+		if err := json.Unmarshal(patch.Data, &value); err != nil {
+			firstErr = errors.Wrap(err, "decode patch data")
+			continue
+		}
+		tree, err = splicePatch(tree, patch.Path, value)
+		if err != nil {
+			firstErr = err
+		}
+	}
+	if firstErr != nil {
+		return firstErr
+	}
+
+	merged, err := json.Marshal(tree)
+	if err != nil {
+		return errors.Wrap(err, "marshal merged data")
+	}
+	return json.Unmarshal(merged, initial)
+}
+
+// splicePatch walks tree along path and returns a new tree with value
+// set at that location. A path element is either a field name (string)
+// or a list index (float64, as decoded by encoding/json); indices past
+// the end of a list grow it, covering @stream appending list items one
+// at a time.
+func splicePatch(tree interface{}, path []interface{}, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	switch key := path[0].(type) {
+	case string:
+		m, _ := tree.(map[string]interface{})
+		if m == nil {
+			m = map[string]interface{}{}
+		}
+		child, err := splicePatch(m[key], path[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		m[key] = child
+		return m, nil
+	case float64:
+		idx := int(key)
+		s, _ := tree.([]interface{})
+		for idx >= len(s) {
+			s = append(s, nil)
+		}
+		child, err := splicePatch(s[idx], path[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		s[idx] = child
+		return s, nil
+	default:
+		return nil, fmt.Errorf("graphql: unsupported patch path element %v (%T)", key, key)
+	}
+}
+
+func (c *Client) runWithPostFieldsAPQ(ctx context.Context, req *Request, resp interface{}) error {
+	hash := persistedQueryHash(req.q)
+	known := c.pqCache.Knows(c.endpoint, hash)
+
+	gr, err := c.doPostFieldsAPQRequest(ctx, req, resp, hash, !known)
+	if err != nil {
+		return err
+	}
+	if isPersistedQueryNotFound(gr) {
+		gr, err = c.doPostFieldsAPQRequest(ctx, req, resp, hash, true)
+		if err != nil {
+			return err
+		}
+	}
+	if !isPersistedQueryNotFound(gr) {
+		c.pqCache.Remember(c.endpoint, hash)
+	}
+
+	if len(gr.Errors) > 0 {
+		return gr.Errors
+	}
+	return nil
+}
+
+func (c *Client) doPostFieldsAPQRequest(ctx context.Context, req *Request, resp interface{}, hash string, includeQuery bool) (*graphResponse, error) {
+	reqStr, err := apqOperationsJSON(req, hash, includeQuery)
+	if err != nil {
+		return nil, errors.Wrap(err, "json marshal failed")
+	}
+
+	var requestBody bytes.Buffer
+	writer := multipart.NewWriter(&requestBody)
+	if err := writer.WriteField("operations", string(reqStr)); err != nil {
+		return nil, errors.Wrap(err, "write query field")
+	}
+	if err := writer.Close(); err != nil {
+		return nil, errors.Wrap(err, "close writer")
+	}
+	c.logf(">> persisted query hash: %s (include query: %v)", hash, includeQuery)
+
+	gr := &graphResponse{Data: resp}
+	r, err := http.NewRequest(http.MethodPost, c.endpoint, &requestBody)
+	if err != nil {
+		return nil, err
+	}
+	r.Close = c.closeReq
+	r.Header.Set("Content-Type", writer.FormDataContentType())
+	r.Header.Set("Accept", "application/json; charset=utf-8")
+	for key, values := range req.Header {
+		for _, value := range values {
+			r.Header.Add(key, value)
+		}
+	}
+	if c.buildHeaderFunc != nil {
+		for key, values := range c.buildHeaderFunc(requestBody.String()) {
+			for _, value := range values {
+				r.Header.Add(key, value)
+			}
+		}
+	}
+	c.logf(">> headers: %v", r.Header)
+	r = r.WithContext(ctx)
+	res, err := c.httpClient.Do(r)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, res.Body); err != nil {
+		return nil, errors.Wrap(err, "reading body")
+	}
+	c.logf("<< %s", buf.String())
+	if err := json.NewDecoder(&buf).Decode(&gr); err != nil {
+		if res.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("graphql: server returned a non-200 status code: %v", res.StatusCode)
+		}
+		return nil, errors.Wrap(err, "decoding response")
+	}
+	return gr, nil
+}
+
 // WithHTTPClient specifies the underlying http.Client to use when
 // making requests.
-//  NewClient(endpoint, WithHTTPClient(specificHTTPClient))
+//
+//	NewClient(endpoint, WithHTTPClient(specificHTTPClient))
 func WithHTTPClient(httpclient *http.Client) ClientOption {
 	return func(client *Client) {
 		client.httpClient = httpclient
@@ -294,28 +795,103 @@ func UseMultipartForm() ClientOption {
 	}
 }
 
-//ImmediatelyCloseReqBody will close the req body immediately after each request body is ready
+// ImmediatelyCloseReqBody will close the req body immediately after each request body is ready
 func ImmediatelyCloseReqBody() ClientOption {
 	return func(client *Client) {
 		client.closeReq = true
 	}
 }
 
+// WithAutomaticPersistedQueries enables Automatic Persisted Queries
+// (APQ): every request carries the query's sha256 hash, and the full
+// query text is left out once the PQCache records that the server has
+// it. A hash the cache does not know yet is sent with the full query
+// text (so the server can cache it), and any PersistedQueryNotFound
+// response is retried once with the full query text. If no PQCache has
+// been set via WithPersistedQueryCache, a bounded in-memory cache is
+// used.
+func WithAutomaticPersistedQueries() ClientOption {
+	return func(client *Client) {
+		client.useAPQ = true
+		if client.pqCache == nil {
+			client.pqCache = newMemoryPQCache(defaultPQCacheSize)
+		}
+	}
+}
+
+// WithPersistedQueryCache sets the PQCache used to remember which
+// persisted-query hashes the server already has, e.g. to share that
+// state across processes. Only takes effect together with
+// WithAutomaticPersistedQueries.
+func WithPersistedQueryCache(cache PQCache) ClientOption {
+	return func(client *Client) {
+		client.pqCache = cache
+	}
+}
+
 // ClientOption are functions that are passed into NewClient to
 // modify the behaviour of the Client.
 type ClientOption func(*Client)
 
-type graphErr struct {
-	Message string
+// SourceLocation is a 1-indexed position in a GraphQL document, as
+// defined by the "locations" entry of the GraphQL spec's error format.
+type SourceLocation struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
 }
 
-func (e graphErr) Error() string {
+// GraphQLError is a single error as returned by a GraphQL server, per
+// the error format from the GraphQL spec. Servers commonly use
+// Extensions to carry structured detail such as an error code (see
+// Code) or rate-limit info.
+type GraphQLError struct {
+	Message    string                 `json:"message"`
+	Locations  []SourceLocation       `json:"locations,omitempty"`
+	Path       []interface{}          `json:"path,omitempty"`
+	Extensions map[string]interface{} `json:"extensions,omitempty"`
+}
+
+func (e GraphQLError) Error() string {
 	return "graphql: " + e.Message
 }
 
+// Code returns the "code" extension servers commonly use to classify
+// errors (e.g. "UNAUTHENTICATED"), or "" if it isn't present.
+func (e GraphQLError) Code() string {
+	code, _ := e.Extensions["code"].(string)
+	return code
+}
+
+// GraphQLErrors aggregates every error in a GraphQL response, letting
+// callers inspect all of them instead of just the first. It supports
+// errors.As(err, &GraphQLError{}), which matches the first error.
+type GraphQLErrors []GraphQLError
+
+func (es GraphQLErrors) Error() string {
+	if len(es) == 1 {
+		return es[0].Error()
+	}
+	msgs := make([]string, len(es))
+	for i, e := range es {
+		msgs[i] = e.Message
+	}
+	return fmt.Sprintf("graphql: %d errors occurred: %s", len(es), strings.Join(msgs, "; "))
+}
+
+func (es GraphQLErrors) As(target interface{}) bool {
+	if len(es) == 0 {
+		return false
+	}
+	if t, ok := target.(*GraphQLError); ok {
+		*t = es[0]
+		return true
+	}
+	return false
+}
+
 type graphResponse struct {
 	Data   interface{}
-	Errors []graphErr
+	Errors GraphQLErrors
 }
 
 // Request is a GraphQL request.
@@ -385,13 +961,88 @@ type File struct {
 }
 
 type SubscriptionClient struct {
-	subWebsocket *websocket.Conn
-	subBuffer    chan subscriptionMessage
-	subWait      sync.WaitGroup
-	subs         sync.Map
-	subIdGen     int
+	subBuffer             chan subscriptionMessage
+	subWait               sync.WaitGroup
+	subs                  sync.Map
+	subIdGen              int
+	protocol              SubscriptionProtocol
+	connectionInitPayload ConnectionInitPayloadFunc
+
+	endpoint string
+	header   http.Header
+
+	mu            sync.Mutex
+	subWebsocket  *websocket.Conn
+	closed        bool
+	lastKeepAlive time.Time
+
+	// writeMu serializes every WriteJSON call made on subWebsocket.
+	// gorilla/websocket only allows one concurrent writer per
+	// connection, and writes here come from several goroutines: the
+	// caller (Subscribe/Unsubscribe), the read loop (pong replies), the
+	// keep-alive monitor (pings), and Close.
+	writeMu sync.Mutex
+
+	pingInterval         time.Duration
+	idleTimeout          time.Duration
+	reconnectMaxAttempts int
+	reconnectBackoff     func(attempt int) time.Duration
+
+	connState chan ConnectionState
+}
+
+// activeSubscription is what c.subs stores for each subscription id: the
+// channel delivering payloads to the caller, and the start/subscribe
+// payload needed to resume it after a reconnect.
+type activeSubscription struct {
+	payload json.RawMessage
+	ch      Subscription
 }
 
+// ConnectionState describes transitions of the underlying websocket
+// connection used by a SubscriptionClient.
+type ConnectionState int
+
+const (
+	StateConnected ConnectionState = iota
+	StateDisconnected
+	StateReconnecting
+	StateReconnected
+	StateClosed
+)
+
+// ConnectionInitPayloadFunc builds the payload sent with the
+// connection_init message, e.g. to carry an auth token or tenant info.
+// It is called each time the SubscriptionClient dials, including on
+// every reconnect.
+type ConnectionInitPayloadFunc func(ctx context.Context) (map[string]interface{}, error)
+
+// ConnectionError is returned by Client.SubscriptionClient when the
+// server rejects the connection_init handshake with a connection_error
+// message, e.g. because the auth token in the init payload was invalid.
+type ConnectionError struct {
+	Payload json.RawMessage
+}
+
+func (e *ConnectionError) Error() string {
+	return "graphql: connection_error: " + string(e.Payload)
+}
+
+// SubscriptionProtocol identifies the WebSocket subprotocol a
+// SubscriptionClient speaks to the server.
+type SubscriptionProtocol string
+
+const (
+	// ProtocolGraphQLWS is the legacy subscriptions-transport-ws protocol
+	// (gql_start, gql_stop, ka, ...). It is the default, for backwards
+	// compatibility.
+	ProtocolGraphQLWS SubscriptionProtocol = "graphql-ws"
+	// ProtocolGraphQLTransportWS is the newer graphql-ws protocol
+	// (subscribe, next, complete, ping, pong, ...) implemented by Apollo
+	// Server, gqlgen and Hasura.
+	ProtocolGraphQLTransportWS SubscriptionProtocol = "graphql-transport-ws"
+)
+
 type subscriptionMessageType string
 
 const (
@@ -405,128 +1056,571 @@ const (
 	gql_error                                         = "error"
 	gql_complete                                      = "GQL_COMPLETE"
 	gql_connection_keep_alive                         = "ka"
+
+	transport_connection_init subscriptionMessageType = "connection_init"
+	transport_connection_ack                          = "connection_ack"
+	transport_subscribe                               = "subscribe"
+	transport_next                                    = "next"
+	transport_error                                   = "error"
+	transport_complete                                = "complete"
+	transport_ping                                    = "ping"
+	transport_pong                                    = "pong"
+)
+
+// messageKind is a wire-protocol-independent identification of what a
+// subscriptionMessage means, so the rest of the client can stay the
+// same no matter which SubscriptionProtocol is in use.
+type messageKind int
+
+const (
+	kindUnknown messageKind = iota
+	kindConnectionInit
+	kindConnectionAck
+	kindConnectionError
+	kindConnectionTerminate
+	kindStart
+	kindStop
+	kindData
+	kindError
+	kindComplete
+	kindKeepAlive
+	kindPing
+	kindPong
 )
 
+// messageTypeFor returns the wire string a given protocol uses for kind.
+func messageTypeFor(protocol SubscriptionProtocol, kind messageKind) subscriptionMessageType {
+	if protocol == ProtocolGraphQLTransportWS {
+		switch kind {
+		case kindConnectionInit:
+			return transport_connection_init
+		case kindConnectionAck:
+			return transport_connection_ack
+		case kindStart:
+			return transport_subscribe
+		case kindStop, kindComplete:
+			return transport_complete
+		case kindData:
+			return transport_next
+		case kindError:
+			return transport_error
+		case kindPing:
+			return transport_ping
+		case kindPong:
+			return transport_pong
+		}
+		return ""
+	}
+	switch kind {
+	case kindConnectionInit:
+		return gql_connection_init
+	case kindConnectionAck:
+		return gql_connection_ack
+	case kindConnectionError:
+		return gql_connection_error
+	case kindConnectionTerminate:
+		return gql_connection_terminate
+	case kindStart:
+		return gql_start
+	case kindStop:
+		return gql_stop
+	case kindData:
+		return gql_data
+	case kindError:
+		return gql_error
+	case kindComplete:
+		return gql_complete
+	case kindKeepAlive:
+		return gql_connection_keep_alive
+	}
+	return ""
+}
+
+// kindForMessageType is the inverse of messageTypeFor: it classifies an
+// incoming wire message so subWork doesn't need to know which protocol
+// is in use.
+func kindForMessageType(protocol SubscriptionProtocol, t subscriptionMessageType) messageKind {
+	if protocol == ProtocolGraphQLTransportWS {
+		switch t {
+		case transport_connection_ack:
+			return kindConnectionAck
+		case transport_next:
+			return kindData
+		case transport_error:
+			return kindError
+		case transport_complete:
+			return kindComplete
+		case transport_ping:
+			return kindPing
+		case transport_pong:
+			return kindPong
+		}
+		return kindUnknown
+	}
+	switch t {
+	case gql_connection_ack:
+		return kindConnectionAck
+	case gql_connection_error:
+		return kindConnectionError
+	case gql_data:
+		return kindData
+	case gql_error:
+		return kindError
+	case gql_complete:
+		return kindComplete
+	case gql_connection_keep_alive:
+		return kindKeepAlive
+	}
+	return kindUnknown
+}
+
 type subscriptionMessage struct {
 	Payload *json.RawMessage        `json:"payload,omitempty"`
 	Id      *string                 `json:"id,omitempty"`
 	Type    subscriptionMessageType `json:"type"`
 }
 
-func (c *Client) SubscriptionClient(ctx context.Context, header http.Header) (*SubscriptionClient, error) {
+// SubscriptionClientOption are functions that are passed into
+// Client.SubscriptionClient to modify the behaviour of the
+// SubscriptionClient before it dials the websocket.
+type SubscriptionClientOption func(*SubscriptionClient)
+
+// WithSubscriptionProtocol selects the WebSocket subprotocol advertised
+// to the server via Sec-WebSocket-Protocol, and the message kinds used
+// on the wire. It defaults to ProtocolGraphQLWS for backwards
+// compatibility.
+func WithSubscriptionProtocol(protocol SubscriptionProtocol) SubscriptionClientOption {
+	return func(c *SubscriptionClient) {
+		c.protocol = protocol
+	}
+}
+
+// WithConnectionInitPayload sets a function that builds the payload sent
+// with the connection_init message, such as an auth token read from ctx.
+func WithConnectionInitPayload(f ConnectionInitPayloadFunc) SubscriptionClientOption {
+	return func(c *SubscriptionClient) {
+		c.connectionInitPayload = f
+	}
+}
+
+// WithConnectionInitPayloadMap sets a static payload map to be sent with
+// the connection_init message.
+func WithConnectionInitPayloadMap(payload map[string]interface{}) SubscriptionClientOption {
+	return WithConnectionInitPayload(func(context.Context) (map[string]interface{}, error) {
+		return payload, nil
+	})
+}
+
+// WithPingInterval makes the SubscriptionClient send a keep-alive ping
+// (graphql-transport-ws) at the given interval, and treats the
+// connection as dropped if no ka/ping/pong is seen for that same
+// duration. A non-positive interval disables ping/idle monitoring.
+func WithPingInterval(d time.Duration) SubscriptionClientOption {
+	return func(c *SubscriptionClient) {
+		c.pingInterval = d
+		c.idleTimeout = d
+	}
+}
+
+// WithReconnect enables automatic reconnection of a dropped
+// subscription websocket. maxAttempts bounds how many redials are tried
+// before giving up and failing every active subscription (a negative
+// value means retry forever). backoff returns how long to wait before
+// the attempt'th redial (attempt starts at 1).
+func WithReconnect(maxAttempts int, backoff func(attempt int) time.Duration) SubscriptionClientOption {
+	return func(c *SubscriptionClient) {
+		c.reconnectMaxAttempts = maxAttempts
+		c.reconnectBackoff = backoff
+	}
+}
+
+func (c *Client) SubscriptionClient(ctx context.Context, header http.Header, opts ...SubscriptionClientOption) (*SubscriptionClient, error) {
+	subClient := &SubscriptionClient{
+		subBuffer: make(chan subscriptionMessage),
+		protocol:  ProtocolGraphQLWS,
+		endpoint:  c.endpoint,
+		header:    header,
+		connState: make(chan ConnectionState, 8),
+	}
+	for _, optionFunc := range opts {
+		optionFunc(subClient)
+	}
+
+	conn, err := subClient.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	subClient.subWebsocket = conn
+	subClient.markAlive()
+
+	subClient.subWait.Add(1)
+	go subClient.run(ctx)
+	return subClient, nil
+}
+
+// dial opens the websocket and performs the connection_init/ack
+// handshake, returning a ready-to-use connection.
+func (c *SubscriptionClient) dial(ctx context.Context) (*websocket.Conn, error) {
 	dialer := websocket.DefaultDialer
-	header.Set("Sec-WebSocket-Protocol", "graphql-ws")
+	header := c.header.Clone()
+	header.Set("Sec-WebSocket-Protocol", string(c.protocol))
 	header.Set("Content-Type", "application/json")
 
 	conn, _, err := dialer.DialContext(ctx, strings.Replace(c.endpoint, "http", "ws", 1), header)
-
 	if err != nil {
 		if conn != nil {
 			_ = conn.Close()
 		}
 		return nil, err
 	}
-	subClient := &SubscriptionClient{
-		subWebsocket: conn,
-		subBuffer:    make(chan subscriptionMessage),
+
+	if err := c.handshake(ctx, conn); err != nil {
+		conn.Close()
+		return nil, err
 	}
+	return conn, nil
+}
 
+func (c *SubscriptionClient) handshake(ctx context.Context, conn *websocket.Conn) error {
 	var msg subscriptionMessage
 
-	msg.Type = gql_connection_init
-	emptyPayload := json.RawMessage("{}")
-	msg.Payload = &emptyPayload
-	err = conn.WriteJSON(msg)
-	if err != nil {
-		return nil, err
+	msg.Type = messageTypeFor(c.protocol, kindConnectionInit)
+	initPayload := json.RawMessage("{}")
+	if c.connectionInitPayload != nil {
+		payload, err := c.connectionInitPayload(ctx)
+		if err != nil {
+			return errors.Wrap(err, "build connection_init payload")
+		}
+		b, err := json.Marshal(payload)
+		if err != nil {
+			return errors.Wrap(err, "encode connection_init payload")
+		}
+		initPayload = json.RawMessage(b)
+	}
+	msg.Payload = &initPayload
+	if err := conn.WriteJSON(msg); err != nil {
+		return err
 	}
 
-	err = conn.ReadJSON(&msg)
-	if err != nil {
-		return nil, err
+	if err := conn.ReadJSON(&msg); err != nil {
+		return err
 	}
 
-	if msg.Type != gql_connection_ack {
-		conn.Close()
-		if msg.Type == gql_connection_error {
-			errJ, _ := json.Marshal(*msg.Payload)
-			return nil, errors.New(string(errJ))
-		} else {
-			return nil, errors.New("server-did-not-acknowledge")
+	if kindForMessageType(c.protocol, msg.Type) != kindConnectionAck {
+		if kindForMessageType(c.protocol, msg.Type) == kindConnectionError {
+			return &ConnectionError{Payload: *msg.Payload}
 		}
+		return errors.New("server-did-not-acknowledge")
 	}
+	return nil
+}
 
-	go subClient.subWork()
-	return subClient, nil
+func (c *SubscriptionClient) conn() *websocket.Conn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.subWebsocket
+}
+
+func (c *SubscriptionClient) setConn(conn *websocket.Conn) {
+	c.mu.Lock()
+	c.subWebsocket = conn
+	c.mu.Unlock()
+}
+
+// writeJSON serializes msg onto the current connection, holding writeMu
+// for the duration so it can never interleave with another writer's
+// frame. Only one goroutine may call a gorilla/websocket conn's
+// WriteJSON/WriteMessage at a time.
+func (c *SubscriptionClient) writeJSON(msg interface{}) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.conn().WriteJSON(msg)
+}
+
+func (c *SubscriptionClient) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+// nextSubID returns the next unique subscription id. Subscribe is meant
+// to be safe for concurrent use, so allocating the id has to be guarded
+// the same as the rest of the client's mutable state.
+func (c *SubscriptionClient) nextSubID() string {
+	c.mu.Lock()
+	id := c.subIdGen
+	c.subIdGen++
+	c.mu.Unlock()
+	return strconv.Itoa(id)
+}
+
+func (c *SubscriptionClient) markAlive() {
+	c.mu.Lock()
+	c.lastKeepAlive = time.Now()
+	c.mu.Unlock()
+}
+
+func (c *SubscriptionClient) keptAliveSince() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return time.Since(c.lastKeepAlive)
+}
+
+// ConnectionState returns a channel of connection lifecycle events.
+// Sends are best-effort: a slow or absent reader does not block the
+// subscription client.
+func (c *SubscriptionClient) ConnectionState() <-chan ConnectionState {
+	return c.connState
+}
+
+func (c *SubscriptionClient) setState(s ConnectionState) {
+	select {
+	case c.connState <- s:
+	default:
+	}
 }
 
 func (c *SubscriptionClient) Close() error {
-	if c.subWebsocket == nil {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
 		return nil
 	}
-	err := c.subWebsocket.WriteJSON(subscriptionMessage{Type: gql_connection_terminate})
-	if err != nil {
+	c.closed = true
+	conn := c.subWebsocket
+	c.mu.Unlock()
+
+	if conn == nil {
+		return nil
+	}
+	if c.protocol == ProtocolGraphQLTransportWS {
+		// graphql-transport-ws has no connection_terminate message;
+		// closing the socket is itself the termination signal.
+		err := conn.Close()
+		c.subWait.Wait()
 		return err
 	}
-
-	c.subWait.Wait()
-	err = c.subWebsocket.Close()
+	err := c.writeJSON(subscriptionMessage{Type: messageTypeFor(c.protocol, kindConnectionTerminate)})
 	if err != nil {
 		return err
 	}
-	return nil
+
+	c.subWait.Wait()
+	return conn.Close()
 }
 
 type SubscriptionPayload struct {
-	Data  *json.RawMessage
-	Error *json.RawMessage
+	Data   *json.RawMessage
+	Errors GraphQLErrors
+}
+
+// decodeSubscriptionErrors parses a subscription "error" message
+// payload: a single GraphQLError object under the legacy graphql-ws
+// protocol, or a GraphQLError array under graphql-transport-ws.
+func decodeSubscriptionErrors(payload *json.RawMessage) GraphQLErrors {
+	if payload == nil {
+		return nil
+	}
+	var errs GraphQLErrors
+	if err := json.Unmarshal(*payload, &errs); err == nil && len(errs) > 0 {
+		return errs
+	}
+	var single GraphQLError
+	if err := json.Unmarshal(*payload, &single); err == nil && single.Message != "" {
+		return GraphQLErrors{single}
+	}
+	return nil
 }
 
 type Subscription chan SubscriptionPayload
 
-func (c *SubscriptionClient) subWork() {
-	c.subWait.Add(1)
+// run supervises the lifetime of the websocket connection: it reads
+// messages until the connection drops, then either reconnects (if
+// WithReconnect was configured) and resumes every active subscription,
+// or fails every active subscription and returns.
+func (c *SubscriptionClient) run(ctx context.Context) {
 	defer c.subWait.Done()
-	defer c.subs.Range(func(_, sub interface{}) bool {
-		close(sub.(Subscription))
-		return true
-	})
 
+	c.setState(StateConnected)
 	for {
-		var msg subscriptionMessage
-		err := c.subWebsocket.ReadJSON(&msg)
+		err := c.readLoop(ctx)
+		if c.isClosed() {
+			c.setState(StateClosed)
+			c.closeAllSubs()
+			return
+		}
 
+		c.setState(StateDisconnected)
+		if c.reconnectBackoff == nil {
+			c.failAllSubs(err)
+			return
+		}
+		if !c.reconnect(ctx) {
+			c.failAllSubs(err)
+			return
+		}
+		c.setState(StateReconnected)
+	}
+}
+
+// readLoop reads messages off the current connection until it errors
+// out (closed by the peer, by Close, or by the idle-timeout monitor).
+func (c *SubscriptionClient) readLoop(ctx context.Context) error {
+	conn := c.conn()
+	stop := make(chan struct{})
+	go c.monitor(conn, stop)
+	defer close(stop)
+
+	for {
+		var msg subscriptionMessage
+		err := conn.ReadJSON(&msg)
 		if err != nil {
-			if err == io.ErrUnexpectedEOF || err == io.EOF {
-				//close every subscription
-				return
+			return err
+		}
+
+		switch kindForMessageType(c.protocol, msg.Type) {
+		case kindError:
+			id := *msg.Id
+			if v, ok := c.subs.Load(id); ok {
+				v.(*activeSubscription).ch <- SubscriptionPayload{Errors: decodeSubscriptionErrors(msg.Payload)}
 			}
-			if strings.HasSuffix(err.Error(), io.ErrUnexpectedEOF.Error()) {
-				return
+		case kindData:
+			id := *msg.Id
+			if v, ok := c.subs.Load(id); ok {
+				v.(*activeSubscription).ch <- SubscriptionPayload{Data: msg.Payload}
 			}
+		case kindComplete:
+			id := *msg.Id
+			if v, ok := c.subs.Load(id); ok {
+				close(v.(*activeSubscription).ch)
+				c.subs.Delete(id)
+			}
+		case kindKeepAlive, kindPong:
+			c.markAlive()
+		case kindPing:
+			c.markAlive()
+			_ = c.writeJSON(subscriptionMessage{Type: messageTypeFor(c.protocol, kindPong)})
+		}
+	}
+}
 
-			log.Fatalf("Error reading from subscription websocket : %s", err)
+// monitor sends periodic pings and watches for an idle connection,
+// closing it to force readLoop to return and trigger a reconnect.
+func (c *SubscriptionClient) monitor(conn *websocket.Conn, stop chan struct{}) {
+	interval := c.pingInterval
+	if interval <= 0 {
+		interval = c.idleTimeout
+	}
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
 			return
+		case <-ticker.C:
+			if c.pingInterval > 0 && c.protocol == ProtocolGraphQLTransportWS {
+				_ = c.writeJSON(subscriptionMessage{Type: messageTypeFor(c.protocol, kindPing)})
+			}
+			if c.idleTimeout > 0 && c.keptAliveSince() > c.idleTimeout {
+				_ = conn.Close()
+				return
+			}
 		}
+	}
+}
 
-		switch msg.Type {
-		case gql_error:
-			id := *msg.Id
-			ch, _ := c.subs.Load(id)
-			ch.(Subscription) <- SubscriptionPayload{Error: msg.Payload}
-		case gql_data:
-			id := *msg.Id
-			ch, _ := c.subs.Load(id)
-			ch.(Subscription) <- SubscriptionPayload{Data: msg.Payload}
-		case gql_complete:
-			id := *msg.Id
-			ch, _ := c.subs.Load(id)
-			close(ch.(Subscription))
-			c.subs.Delete(id)
+// reconnect redials with backoff until it succeeds, the context is
+// cancelled, or reconnectMaxAttempts is exhausted.
+func (c *SubscriptionClient) reconnect(ctx context.Context) bool {
+	c.setState(StateReconnecting)
+	for attempt := 1; c.reconnectMaxAttempts < 0 || attempt <= c.reconnectMaxAttempts; attempt++ {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(c.reconnectBackoff(attempt)):
+		}
+
+		if c.isClosed() {
+			return false
+		}
+
+		conn, err := c.dial(ctx)
+		if err != nil {
+			continue
+		}
+		// Close may have run while we were dialing/resubscribing. Don't
+		// commit a freshly-dialed connection once the client is closed:
+		// run() would keep looping on it forever and Close's
+		// subWait.Wait() would never return.
+		if c.isClosed() {
+			conn.Close()
+			return false
+		}
+		c.setConn(conn)
+		c.markAlive()
+		if err := c.resubscribeAll(); err != nil {
+			conn.Close()
+			continue
+		}
+		if c.isClosed() {
+			conn.Close()
+			return false
+		}
+		return true
+	}
+	return false
+}
 
-		case gql_connection_keep_alive: //ignore...
+// resubscribeAll re-issues a start/subscribe message for every
+// subscription still in c.subs, using its original id and payload, so
+// callers keep receiving on the same Subscription channel after a
+// reconnect.
+func (c *SubscriptionClient) resubscribeAll() error {
+	var outerErr error
+	c.subs.Range(func(key, value interface{}) bool {
+		id := key.(string)
+		sub := value.(*activeSubscription)
+		if err := c.sendStart(id, sub.payload); err != nil {
+			outerErr = err
+			return false
 		}
+		return true
+	})
+	return outerErr
+}
+
+func (c *SubscriptionClient) closeAllSubs() {
+	c.subs.Range(func(key, value interface{}) bool {
+		close(value.(*activeSubscription).ch)
+		c.subs.Delete(key)
+		return true
+	})
+}
+
+// failAllSubs delivers err to every active subscription before closing
+// its channel, so callers observe why the subscription ended instead of
+// just seeing the channel close.
+func (c *SubscriptionClient) failAllSubs(err error) {
+	errs := GraphQLErrors{{Message: err.Error()}}
+	c.subs.Range(func(key, value interface{}) bool {
+		sub := value.(*activeSubscription)
+		sub.ch <- SubscriptionPayload{Errors: errs}
+		close(sub.ch)
+		c.subs.Delete(key)
+		return true
+	})
+}
+
+func (c *SubscriptionClient) sendStart(id string, payload json.RawMessage) error {
+	sReq := subscriptionMessage{
+		Payload: &payload,
+		Id:      &id,
+		Type:    messageTypeFor(c.protocol, kindStart),
 	}
+	return c.writeJSON(sReq)
 }
 
 func (c *SubscriptionClient) Subscribe(req *Request) (Subscription, error) {
@@ -542,20 +1636,14 @@ func (c *SubscriptionClient) Subscribe(req *Request) (Subscription, error) {
 	if err := json.NewEncoder(&requestBody).Encode(requestBodyObj); err != nil {
 		return nil, errors.Wrap(err, "encode body")
 	}
-	id := strconv.Itoa(c.subIdGen)
-	c.subIdGen++
+	id := c.nextSubID()
 
 	payload := json.RawMessage(requestBody.Bytes())
-	sReq := subscriptionMessage{
-		Payload: &payload,
-		Id:      &id,
-		Type:    gql_start,
-	}
-
 	subChan := make(Subscription)
-	c.subs.Store(id, subChan)
-	err := c.subWebsocket.WriteJSON(sReq)
-	if err != nil {
+	c.subs.Store(id, &activeSubscription{payload: payload, ch: subChan})
+
+	if err := c.sendStart(id, payload); err != nil {
+		c.subs.Delete(id)
 		return nil, err
 	}
 
@@ -564,9 +1652,15 @@ func (c *SubscriptionClient) Subscribe(req *Request) (Subscription, error) {
 
 func (c *SubscriptionClient) Unsubscribe(sub Subscription) {
 	c.subs.Range(func(key interface{}, value interface{}) bool {
-		if value == sub {
+		if value.(*activeSubscription).ch == sub {
 			id := key.(string)
-			_ = c.subWebsocket.WriteJSON(subscriptionMessage{Id: &id, Type: gql_stop})
+			// Remove the subscription eagerly rather than waiting for the
+			// server to echo back a complete message: if the stop message
+			// never makes it across (or the connection drops before the
+			// ack arrives), resubscribeAll must not resurrect a
+			// subscription the caller already considers done.
+			c.subs.Delete(id)
+			_ = c.writeJSON(subscriptionMessage{Id: &id, Type: messageTypeFor(c.protocol, kindStop)})
 			return false
 		}
 		return true
@@ -0,0 +1,113 @@
+package graphql
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestSplicePatchSetsNestedField(t *testing.T) {
+	var tree interface{}
+	if err := json.Unmarshal([]byte(`{"user":{"name":"alice"}}`), &tree); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := splicePatch(tree, []interface{}{"user", "age"}, float64(30))
+	if err != nil {
+		t.Fatalf("splicePatch: %v", err)
+	}
+
+	want := map[string]interface{}{
+		"user": map[string]interface{}{
+			"name": "alice",
+			"age":  float64(30),
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("splicePatch result = %#v, want %#v", got, want)
+	}
+}
+
+func TestSplicePatchGrowsListForStream(t *testing.T) {
+	var tree interface{}
+	if err := json.Unmarshal([]byte(`{"items":["a"]}`), &tree); err != nil {
+		t.Fatal(err)
+	}
+
+	// @stream delivers one new list element at a time, addressed by the
+	// index it lands at, which is always one past the current end.
+	got, err := splicePatch(tree, []interface{}{"items", float64(1)}, "b")
+	if err != nil {
+		t.Fatalf("splicePatch: %v", err)
+	}
+
+	want := map[string]interface{}{
+		"items": []interface{}{"a", "b"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("splicePatch result = %#v, want %#v", got, want)
+	}
+}
+
+func TestSplicePatchSkipsAheadGrowsWithNils(t *testing.T) {
+	var tree interface{}
+	if err := json.Unmarshal([]byte(`{"items":[]}`), &tree); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := splicePatch(tree, []interface{}{"items", float64(2)}, "c")
+	if err != nil {
+		t.Fatalf("splicePatch: %v", err)
+	}
+
+	want := map[string]interface{}{
+		"items": []interface{}{nil, nil, "c"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("splicePatch result = %#v, want %#v", got, want)
+	}
+}
+
+func TestSplicePatchEmptyPathReplacesValue(t *testing.T) {
+	got, err := splicePatch(map[string]interface{}{"old": true}, nil, "new")
+	if err != nil {
+		t.Fatalf("splicePatch: %v", err)
+	}
+	if got != "new" {
+		t.Errorf("splicePatch with empty path = %#v, want %q", got, "new")
+	}
+}
+
+func TestSplicePatchRejectsUnsupportedPathElement(t *testing.T) {
+	_, err := splicePatch(map[string]interface{}{}, []interface{}{true}, "x")
+	if err == nil {
+		t.Fatal("expected an error for an unsupported path element, got nil")
+	}
+}
+
+func TestApplyPatchesDrainsChannelAfterError(t *testing.T) {
+	patches := make(chan Patch)
+	done := make(chan error, 1)
+
+	var initial struct {
+		User struct {
+			Name string `json:"name"`
+		} `json:"user"`
+	}
+	initial.User.Name = "alice"
+
+	go func() {
+		done <- ApplyPatches(&initial, patches)
+	}()
+
+	patches <- Patch{Errors: GraphQLErrors{{Message: "boom"}}, HasNext: true}
+	// ApplyPatches must keep receiving even after the error so this send
+	// can't block forever.
+	patches <- Patch{Path: []interface{}{"user", "name"}, Data: json.RawMessage(`"bob"`)}
+	close(patches)
+
+	err := <-done
+	if err == nil || err.Error() != "graphql: boom" {
+		t.Fatalf("ApplyPatches error = %v, want %q", err, "graphql: boom")
+	}
+}
@@ -0,0 +1,81 @@
+package graphql
+
+import "testing"
+
+func TestMessageTypeForGraphQLWS(t *testing.T) {
+	cases := []struct {
+		kind messageKind
+		want subscriptionMessageType
+	}{
+		{kindConnectionInit, gql_connection_init},
+		{kindConnectionAck, gql_connection_ack},
+		{kindStart, gql_start},
+		{kindStop, gql_stop},
+		{kindData, gql_data},
+		{kindError, gql_error},
+		{kindComplete, gql_complete},
+	}
+	for _, c := range cases {
+		if got := messageTypeFor(ProtocolGraphQLWS, c.kind); got != c.want {
+			t.Errorf("messageTypeFor(ProtocolGraphQLWS, %v) = %q, want %q", c.kind, got, c.want)
+		}
+	}
+}
+
+func TestMessageTypeForGraphQLTransportWS(t *testing.T) {
+	cases := []struct {
+		kind messageKind
+		want subscriptionMessageType
+	}{
+		{kindConnectionInit, transport_connection_init},
+		{kindConnectionAck, transport_connection_ack},
+		{kindStart, transport_subscribe},
+		{kindStop, transport_complete},
+		{kindComplete, transport_complete},
+		{kindData, transport_next},
+		{kindError, transport_error},
+		{kindPing, transport_ping},
+		{kindPong, transport_pong},
+	}
+	for _, c := range cases {
+		if got := messageTypeFor(ProtocolGraphQLTransportWS, c.kind); got != c.want {
+			t.Errorf("messageTypeFor(ProtocolGraphQLTransportWS, %v) = %q, want %q", c.kind, got, c.want)
+		}
+	}
+}
+
+func TestKindForMessageTypeUnknown(t *testing.T) {
+	if got := kindForMessageType(ProtocolGraphQLWS, "not-a-real-type"); got != kindUnknown {
+		t.Errorf("kindForMessageType of an unrecognized type = %v, want kindUnknown", got)
+	}
+	if got := kindForMessageType(ProtocolGraphQLTransportWS, "not-a-real-type"); got != kindUnknown {
+		t.Errorf("kindForMessageType of an unrecognized type = %v, want kindUnknown", got)
+	}
+}
+
+func TestNextSubIDUniqueUnderConcurrency(t *testing.T) {
+	c := &SubscriptionClient{}
+	const n = 100
+	ids := make(chan string, n)
+	done := make(chan struct{})
+	for i := 0; i < n; i++ {
+		go func() {
+			ids <- c.nextSubID()
+			done <- struct{}{}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		<-done
+	}
+	close(ids)
+	seen := make(map[string]bool, n)
+	for id := range ids {
+		if seen[id] {
+			t.Fatalf("nextSubID returned duplicate id %q", id)
+		}
+		seen[id] = true
+	}
+	if len(seen) != n {
+		t.Fatalf("got %d unique ids, want %d", len(seen), n)
+	}
+}